@@ -0,0 +1,26 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// EventListener contains callbacks that are invoked on Pebble events. Unset
+// callbacks are no-ops, so implementers only need to set the callbacks they
+// care about. It grows as more of Pebble's internals are made observable
+// through this file.
+type EventListener struct {
+	// PacerRateChanged is invoked whenever an auto-tuned pacer (see
+	// PacerOptions.AutoTunePacers) adjusts its rate limit. kind is either
+	// "flush" or "compaction".
+	PacerRateChanged func(kind string, oldLimit, newLimit uint64)
+}
+
+// EnsureDefaults replaces all unset callbacks with no-ops, so that
+// EventListener's callbacks can always be invoked without a nil check. It is
+// valid to call EnsureDefaults on a zero-valued EventListener.
+func (l EventListener) EnsureDefaults() EventListener {
+	if l.PacerRateChanged == nil {
+		l.PacerRateChanged = func(kind string, oldLimit, newLimit uint64) {}
+	}
+	return l
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Metrics holds metrics for various subsystems of a DB. It grows as more of
+// those subsystems are instrumented.
+type Metrics struct {
+	Flush struct {
+		// ThrottledBytes is the cumulative number of bytes iterated while
+		// the flush pacer was throttling.
+		//
+		// ThrottledBytes and ThrottledNanos are updated from flush/compaction
+		// goroutines and may be read concurrently, so they use atomics rather
+		// than plain fields.
+		ThrottledBytes atomic.Uint64
+		// ThrottledNanos is the cumulative amount of time, in nanoseconds,
+		// the flush pacer has spent waiting on its rate limiter. Use
+		// time.Duration(m.Flush.ThrottledNanos.Load()) to read it as a
+		// Duration.
+		ThrottledNanos atomic.Int64
+	}
+
+	Compact struct {
+		// ThrottledBytes is the cumulative number of bytes iterated while
+		// the compaction pacer was throttling. See Flush.ThrottledBytes.
+		ThrottledBytes atomic.Uint64
+		// ThrottledNanos is the cumulative amount of time, in nanoseconds,
+		// the compaction pacer has spent waiting on its rate limiter. See
+		// Flush.ThrottledNanos.
+		ThrottledNanos atomic.Int64
+	}
+
+	WriteStall struct {
+		// Count is the cumulative number of writes that were stalled (either
+		// slowed down or blocked outright) by the WriteStallController.
+		Count int64
+		// Duration is the cumulative amount of time writes have spent
+		// stalled.
+		Duration time.Duration
+		// Current is the amount of time the write stall presently in
+		// progress has lasted so far. It is zero when no write is currently
+		// stalled. WriteStallController does not set this field;
+		// WriteStallController.CurrentStall returns the value it describes.
+		Current time.Duration
+	}
+}
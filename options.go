@@ -0,0 +1,60 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+// Options holds the parameters that configure the behavior of a DB. Only the
+// subset of options touched by the pacer and write-stall machinery lives here
+// so far; it grows as more of Pebble's public configuration surface is
+// exposed through this file.
+type Options struct {
+	// PacerOptions configures the flush and compaction pacers.
+	PacerOptions PacerOptions
+
+	// L0SlowdownWritesTrigger is the soft limit on the number of L0 files.
+	// Once this many L0 files have accumulated, WriteStallController begins
+	// throttling writes via WaitForAdmission.
+	L0SlowdownWritesTrigger int
+
+	// L0StopWritesTrigger is the hard limit on the number of L0 files. Once
+	// this many L0 files have accumulated, WriteStallController blocks
+	// writes entirely until a flush or compaction brings the count back
+	// down.
+	L0StopWritesTrigger int
+
+	// MemTableStopWritesThreshold is the hard limit on the number of queued
+	// (immutable, not yet flushed) memtables. Once this many memtables are
+	// queued, WriteStallController blocks writes entirely until a flush
+	// completes.
+	MemTableStopWritesThreshold int
+
+	// EventListener is invoked on internal Pebble events, such as an
+	// auto-tuned pacer adjusting its rate limit.
+	EventListener EventListener
+}
+
+// EnsureDefaults ensures that the default values for all options are set if a
+// valid value was not already specified. It is valid to call EnsureDefaults
+// on a nil receiver, in which case a new, fully-initialized Options is
+// returned.
+func (o *Options) EnsureDefaults() *Options {
+	if o == nil {
+		o = &Options{}
+	}
+	o.EventListener = o.EventListener.EnsureDefaults()
+	if o.PacerOptions.EventListener.PacerRateChanged == nil {
+		o.PacerOptions.EventListener = o.EventListener
+	}
+	o.PacerOptions.EnsureDefaults()
+	if o.L0SlowdownWritesTrigger == 0 {
+		o.L0SlowdownWritesTrigger = 20
+	}
+	if o.L0StopWritesTrigger == 0 {
+		o.L0StopWritesTrigger = 36
+	}
+	if o.MemTableStopWritesThreshold == 0 {
+		o.MemTableStopWritesThreshold = 2
+	}
+	return o
+}
@@ -15,12 +15,132 @@ import (
 
 var nilPacer = &noopPacer{}
 
+// Pacer kinds, used both as the Metrics section a pacer's throttling is
+// recorded under and as the "kind" argument to EventListener.PacerRateChanged.
+const (
+	pacerKindFlush      = "flush"
+	pacerKindCompaction = "compaction"
+)
+
 type limiter interface {
 	WaitN(ctx context.Context, n int) (err error)
 	AllowN(now time.Time, n int) bool
 	Burst() int
 }
 
+// RateLimiter is the interface implemented by the rate limiter used to pace
+// flushes and compactions. It is satisfied by *internal/rate.Limiter, but
+// callers may supply their own implementation (e.g. a limiter shared across
+// multiple stores) via PacerOptions.RateLimiter.
+type RateLimiter = limiter
+
+// RatePacerOptions configures the tuning knobs of a single rate-limited
+// pacer (either the flush pacer or the compaction pacer). The zero value is
+// not valid; EnsureDefaults fills in values that preserve Pebble's historical
+// pacing behavior.
+type RatePacerOptions struct {
+	// MinRate is the floor, in bytes/sec, that an auto-tuned pacer will
+	// throttle down to.
+	MinRate uint64
+	// MaxRate is the ceiling, in bytes/sec, that an auto-tuned pacer will
+	// throttle up to, and the rate it starts at.
+	MaxRate uint64
+	// LowWatermarkPercent and HighWatermarkPercent bound the "drained
+	// percent" (the fraction of the current rate limit actually consumed
+	// during an adjustment interval; MaxRate before the first adjustment)
+	// that an auto-tuned pacer considers healthy. Below
+	// LowWatermarkPercent the rate is shrunk; above HighWatermarkPercent it
+	// is grown.
+	LowWatermarkPercent  int
+	HighWatermarkPercent int
+	// AdjustmentFactorPercent is the percentage by which the rate is grown
+	// or shrunk on each adjustment.
+	AdjustmentFactorPercent int
+	// RecalculateInterval is how often an auto-tuned pacer reconsiders its
+	// rate.
+	RecalculateInterval time.Duration
+	// RefillsPerSecond is the number of times per second the rate limiter's
+	// token bucket is refilled.
+	RefillsPerSecond int
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver, in
+// which case a new, fully-initialized RatePacerOptions is returned.
+func (o *RatePacerOptions) EnsureDefaults() *RatePacerOptions {
+	if o == nil {
+		o = &RatePacerOptions{}
+	}
+	if o.MinRate == 0 {
+		o.MinRate = minimumRate
+	}
+	if o.MaxRate == 0 {
+		o.MaxRate = maximumRate
+	}
+	if o.LowWatermarkPercent == 0 {
+		o.LowWatermarkPercent = lowWatermarkPercent
+	}
+	if o.HighWatermarkPercent == 0 {
+		o.HighWatermarkPercent = highWatermarkPercent
+	}
+	if o.AdjustmentFactorPercent == 0 {
+		o.AdjustmentFactorPercent = adjustmentFactorPercent
+	}
+	if o.RecalculateInterval == 0 {
+		o.RecalculateInterval = recalculateInterval
+	}
+	if o.RefillsPerSecond == 0 {
+		o.RefillsPerSecond = refillsPerSecond
+	}
+	return o
+}
+
+// PacerOptions configures the flush and compaction pacers, which keep
+// background IO (memtable flushing and compaction) in line with the rate of
+// foreground writes.
+type PacerOptions struct {
+	// Flush configures the auto-tuned flush pacer.
+	Flush RatePacerOptions
+	// Compaction configures the auto-tuned compaction pacer.
+	Compaction RatePacerOptions
+	// FlushSlowdownThresholdPercent is the percentage of memtable size
+	// (relative to 100%) above which the non-auto-tuned flush pacer lets
+	// flushes proceed unthrottled. Defaults to 105.
+	FlushSlowdownThresholdPercent int
+	// RateLimiter, if non-nil, is used by the auto-tuned pacers in place of
+	// a private internal/rate.Limiter, and by the manual-watermark pacers in
+	// place of the limiter in their environment. This allows an embedder to, for
+	// example, share a single token bucket across several pacers or several
+	// Pebble instances.
+	RateLimiter RateLimiter
+	// AutoTunePacers switches the flush and compaction pacers from their
+	// manual-watermark implementations (flushPacer, compactionPacer) to the
+	// AIMD-based auto-tuned implementations (autoTunedFlushPacer,
+	// autoTunedCompactionPacer), which converge on a rate that matches the
+	// workload instead of reacting to a fixed debt threshold.
+	AutoTunePacers bool
+	// EventListener, if set, has its PacerRateChanged callback invoked
+	// whenever an auto-tuned pacer adjusts its rate limit. Normally this is
+	// populated from Options.EventListener by Options.EnsureDefaults.
+	EventListener EventListener
+}
+
+// EnsureDefaults ensures that the default values for all of the options have
+// been initialized. It is valid to call EnsureDefaults on a nil receiver, in
+// which case a new, fully-initialized PacerOptions is returned.
+func (o *PacerOptions) EnsureDefaults() *PacerOptions {
+	if o == nil {
+		o = &PacerOptions{}
+	}
+	o.Flush.EnsureDefaults()
+	o.Compaction.EnsureDefaults()
+	if o.FlushSlowdownThresholdPercent == 0 {
+		o.FlushSlowdownThresholdPercent = flushSlowdownThresholdPercent
+	}
+	o.EventListener = o.EventListener.EnsureDefaults()
+	return o
+}
+
 // pacer is the interface for flush and compaction rate limiters. The rate limiter
 // is possible applied on each iteration step of a flush or compaction. This is to
 // limit background IO usage so that it does not contend with foreground traffic.
@@ -32,6 +152,8 @@ type pacer interface {
 // flushPacer.
 type internalPacer struct {
 	limiter limiter
+	kind    string
+	metrics *Metrics
 
 	iterCount             uint64
 	prevBytesIterated     uint64
@@ -43,6 +165,8 @@ type internalPacer struct {
 // threshold.
 func (p *internalPacer) limit(amount, currentLevel uint64) error {
 	if currentLevel <= p.slowdownThreshold {
+		start := time.Now()
+		throttledBytes := amount
 		burst := p.limiter.Burst()
 		for amount > uint64(burst) {
 			err := p.limiter.WaitN(context.Background(), burst)
@@ -55,6 +179,7 @@ func (p *internalPacer) limit(amount, currentLevel uint64) error {
 		if err != nil {
 			return err
 		}
+		p.recordThrottle(throttledBytes, time.Since(start))
 	} else {
 		burst := p.limiter.Burst()
 		for amount > uint64(burst) {
@@ -66,6 +191,24 @@ func (p *internalPacer) limit(amount, currentLevel uint64) error {
 	return nil
 }
 
+// recordThrottle records bytesThrottled/duration against the Metrics section
+// matching p.kind. It is a no-op if no Metrics was supplied. This is called
+// from flush/compaction goroutines while DB.Metrics may concurrently read
+// the same fields, so the updates must be atomic.
+func (p *internalPacer) recordThrottle(bytesThrottled uint64, duration time.Duration) {
+	if p.metrics == nil {
+		return
+	}
+	switch p.kind {
+	case pacerKindFlush:
+		p.metrics.Flush.ThrottledBytes.Add(bytesThrottled)
+		p.metrics.Flush.ThrottledNanos.Add(int64(duration))
+	case pacerKindCompaction:
+		p.metrics.Compact.ThrottledBytes.Add(bytesThrottled)
+		p.metrics.Compact.ThrottledNanos.Add(int64(duration))
+	}
+}
+
 // compactionPacerInfo contains information necessary for compaction pacing.
 type compactionPacerInfo struct {
 	// slowdownThreshold is the low watermark for compaction debt. If compaction debt is
@@ -97,11 +240,17 @@ type compactionPacer struct {
 	totalCompactionDebt uint64
 }
 
-func newCompactionPacer(env compactionPacerEnv) *compactionPacer {
+func newCompactionPacer(env compactionPacerEnv, opts PacerOptions, metrics *Metrics) *compactionPacer {
+	limiter := env.limiter
+	if opts.RateLimiter != nil {
+		limiter = opts.RateLimiter
+	}
 	return &compactionPacer{
 		env: env,
 		internalPacer: internalPacer{
-			limiter: env.limiter,
+			limiter: limiter,
+			kind:    pacerKindCompaction,
+			metrics: metrics,
 		},
 	}
 }
@@ -168,12 +317,19 @@ type flushPacer struct {
 	totalBytes uint64
 }
 
-func newFlushPacer(env flushPacerEnv) *flushPacer {
+func newFlushPacer(env flushPacerEnv, opts PacerOptions, metrics *Metrics) *flushPacer {
+	limiter := env.limiter
+	if opts.RateLimiter != nil {
+		limiter = opts.RateLimiter
+	}
+	opts = *opts.EnsureDefaults()
 	return &flushPacer{
 		env: env,
 		internalPacer: internalPacer{
-			limiter:           env.limiter,
-			slowdownThreshold: env.memTableSize*105/100,
+			limiter:           limiter,
+			kind:              pacerKindFlush,
+			metrics:           metrics,
+			slowdownThreshold: env.memTableSize * uint64(opts.FlushSlowdownThresholdPercent) / 100,
 		},
 	}
 }
@@ -216,37 +372,214 @@ func (p *flushPacer) maybeThrottle(bytesIterated uint64) error {
 }
 
 const (
-	// Interval in which we readjust the rate (in ms).
+	// Interval in which we readjust the rate (in ms). These are the defaults
+	// used when the caller does not supply a PacerOptions; see
+	// RatePacerOptions.EnsureDefaults.
 	recalculateInterval = time.Millisecond * 100
 	refillsPerSecond    = 10
 
 	maximumRate          = 1000 << 20 // 1 GB/s
-	minimumRate          = 50 << 20 // 50 MB/s
+	minimumRate          = 50 << 20   // 50 MB/s
 	lowWatermarkPercent  = 50
 	highWatermarkPercent = 90
 
 	// Tune by 5% each time.
 	adjustmentFactorPercent = 5
+
+	// flushSlowdownThresholdPercent is the default percentage of memtable
+	// size above which the non-auto-tuned flush pacer lets flushes proceed
+	// unthrottled.
+	flushSlowdownThresholdPercent = 105
 )
 
-type autoTunedCompactionPacer struct {
-	limiter *rate.Limiter
+// TunableRateLimiter is the interface an auto-tuned pacer (see
+// PacerOptions.AutoTunePacers) needs from PacerOptions.RateLimiter: beyond
+// the plain RateLimiter methods, it must expose its current limit and allow
+// that limit to be adjusted, since that is the whole point of auto-tuning.
+// *internal/rate.Limiter satisfies this via the rateLimiterAdapter below; a
+// caller providing its own RateLimiter to an auto-tuned pacer must implement
+// TunableRateLimiter directly.
+type TunableRateLimiter interface {
+	RateLimiter
+	// Limit returns the rate limiter's current limit, in bytes/sec.
+	Limit() float64
+	// SetLimit adjusts the rate limiter's limit, in bytes/sec.
+	SetLimit(limit float64)
+}
+
+// rateLimiterAdapter adapts *internal/rate.Limiter, whose Limit/SetLimit
+// operate on the internal rate.Limit type, to TunableRateLimiter.
+type rateLimiterAdapter struct {
+	*rate.Limiter
+}
+
+func (a rateLimiterAdapter) Limit() float64         { return float64(a.Limiter.Limit()) }
+func (a rateLimiterAdapter) SetLimit(limit float64) { a.Limiter.SetLimit(rate.Limit(limit)) }
+
+// resolveTunableLimiter returns the TunableRateLimiter an auto-tuned pacer
+// should use. If rl is nil, a private *internal/rate.Limiter is created. If
+// rl already implements TunableRateLimiter (including *internal/rate.Limiter
+// itself, via rateLimiterAdapter), it is used as-is, so that a caller's
+// injected limiter is actually honored rather than silently discarded. Any
+// other RateLimiter implementation cannot be auto-tuned, so this returns an
+// error rather than quietly falling back to a private limiter or panicking
+// on an otherwise valid Options combination.
+func resolveTunableLimiter(rl RateLimiter, maxRate uint64) (TunableRateLimiter, error) {
+	switch l := rl.(type) {
+	case nil:
+		return rateLimiterAdapter{rate.NewLimiter(rate.Limit(maxRate), math.MaxInt32)}, nil
+	case TunableRateLimiter:
+		return l, nil
+	case *rate.Limiter:
+		return rateLimiterAdapter{l}, nil
+	default:
+		return nil, errors.New("pebble: PacerOptions.RateLimiter must implement pebble.TunableRateLimiter to be used with an auto-tuned pacer")
+	}
+}
+
+// aimdController implements the AIMD-style rate adjustment (both the increase
+// and the decrease are multiplicative) shared by the auto-tuned compaction and flush
+// pacers: every RecalculateInterval, it looks at what fraction of the
+// limiter's capacity was actually drained and shrinks the rate by
+// AdjustmentFactorPercent if that fraction fell below LowWatermarkPercent, or
+// grows it if the fraction rose above HighWatermarkPercent. Keeping this
+// logic in one place means both pacers converge on a workload-matching rate
+// the same way, and it can be tested independently of either pacer.
+type aimdController struct {
+	limiter TunableRateLimiter
+	opts    RatePacerOptions
+	kind    string
+	metrics *Metrics
+	events  EventListener
+
+	// now returns the current time. It defaults to time.Now, but tests and
+	// `pebble tool pacer simulate` inject a synthetic clock driven off a
+	// trace's timestamps so that results don't depend on wall-clock time.
+	now func() time.Time
 
 	// last time the limiter was adjusted
 	lastRefresh time.Time
 
 	curAmount   int
 	maxCapacity int
+}
+
+// newAIMDController constructs an aimdController. now, if non-nil, is used in
+// place of time.Now as the controller's clock; tests and
+// `pebble tool pacer simulate` pass a synthetic clock here so that
+// lastRefresh is seeded from the same clock throttle will later read,
+// instead of from a wall-clock timestamp a simulated clock can never catch
+// up to.
+func newAIMDController(
+	kind string,
+	opts RatePacerOptions,
+	limiter TunableRateLimiter,
+	metrics *Metrics,
+	events EventListener,
+	now func() time.Time,
+) *aimdController {
+	if now == nil {
+		now = time.Now
+	}
+	c := &aimdController{
+		limiter: limiter,
+		opts:    opts,
+		kind:    kind,
+		metrics: metrics,
+		events:  events.EnsureDefaults(),
+		now:     now,
+	}
+	c.lastRefresh = c.now()
+	c.maxCapacity = capacityFor(opts.MaxRate, opts.RefillsPerSecond)
+	return c
+}
+
+// capacityFor returns the per-refill capacity for rate/refillsPerSecond,
+// clamped to at least 1 so that it is always safe to divide by.
+// RefillsPerSecond and rate are both caller-configurable (PacerOptions), so a
+// small rate or large refill count can otherwise drive this to zero.
+func capacityFor(rate uint64, refillsPerSecond int) int {
+	capacity := int(rate) / refillsPerSecond
+	if capacity == 0 {
+		capacity = 1
+	}
+	return capacity
+}
+
+// throttle waits for amount tokens from the limiter and, once per
+// RecalculateInterval, reconsiders the limiter's rate based on the fraction
+// of capacity drained since the last adjustment.
+func (c *aimdController) throttle(amount int) error {
+	start := c.now()
+	if err := c.limiter.WaitN(context.Background(), amount); err != nil {
+		return err
+	}
+	if c.metrics != nil {
+		elapsed := int64(c.now().Sub(start))
+		switch c.kind {
+		case pacerKindFlush:
+			c.metrics.Flush.ThrottledBytes.Add(uint64(amount))
+			c.metrics.Flush.ThrottledNanos.Add(elapsed)
+		case pacerKindCompaction:
+			c.metrics.Compact.ThrottledBytes.Add(uint64(amount))
+			c.metrics.Compact.ThrottledNanos.Add(elapsed)
+		}
+	}
+	c.curAmount += amount
+
+	now := c.now()
+	elapsedTime := now.Sub(c.lastRefresh)
+	if elapsedTime > c.opts.RecalculateInterval {
+		c.lastRefresh = now
+
+		// Computed in floating point: maxCapacity is typically far larger
+		// than a single interval's curAmount for any workload well below
+		// MaxRate, so truncating integer division here would make
+		// drainedPercent collapse to 0 for most real workloads.
+		drainedPercent := (float64(c.curAmount) / float64(c.maxCapacity)) *
+			(float64(elapsedTime) / float64(c.opts.RecalculateInterval)) * 100
+
+		oldLimit := uint64(c.limiter.Limit())
+		if drainedPercent < float64(c.opts.LowWatermarkPercent) {
+			limit := oldLimit * uint64(100-c.opts.AdjustmentFactorPercent) / 100
+			if limit > c.opts.MinRate {
+				c.limiter.SetLimit(float64(limit))
+				c.events.PacerRateChanged(c.kind, oldLimit, limit)
+			}
+		} else if drainedPercent > float64(c.opts.HighWatermarkPercent) {
+			limit := oldLimit * uint64(100+c.opts.AdjustmentFactorPercent) / 100
+			if limit < c.opts.MaxRate {
+				c.limiter.SetLimit(float64(limit))
+				c.events.PacerRateChanged(c.kind, oldLimit, limit)
+			}
+		}
+
+		c.curAmount = 0
+		c.maxCapacity = capacityFor(uint64(c.limiter.Limit()), c.opts.RefillsPerSecond)
+	}
+
+	return nil
+}
+
+// autoTunedCompactionPacer rate limits compactions using an AIMD control
+// loop that converges on the rate at which compactions are actually able to
+// drain compaction debt, rather than relying on a debt-threshold snapshot
+// like compactionPacer.
+type autoTunedCompactionPacer struct {
+	aimd *aimdController
 
 	prevBytesIterated uint64
 }
 
-func newAutoTunedCompactionPacer() *autoTunedCompactionPacer {
-	return &autoTunedCompactionPacer{
-		limiter:     rate.NewLimiter(maximumRate, math.MaxInt32),
-		lastRefresh: time.Now(),
-		maxCapacity: maximumRate / refillsPerSecond,
+func newAutoTunedCompactionPacer(opts PacerOptions, metrics *Metrics) (*autoTunedCompactionPacer, error) {
+	opts = *opts.EnsureDefaults()
+	limiter, err := resolveTunableLimiter(opts.RateLimiter, opts.Compaction.MaxRate)
+	if err != nil {
+		return nil, err
 	}
+	return &autoTunedCompactionPacer{
+		aimd: newAIMDController(pacerKindCompaction, opts.Compaction, limiter, metrics, opts.EventListener, nil),
+	}, nil
 }
 
 func (p *autoTunedCompactionPacer) maybeThrottle(bytesIterated uint64) error {
@@ -255,42 +588,64 @@ func (p *autoTunedCompactionPacer) maybeThrottle(bytesIterated uint64) error {
 		compactAmount = int(bytesIterated - p.prevBytesIterated)
 	}
 	p.prevBytesIterated = bytesIterated
+	return p.aimd.throttle(compactAmount)
+}
 
-	err := p.limiter.WaitN(context.Background(), int(compactAmount))
-	if err != nil {
-		return err
-	}
-
-	p.curAmount += compactAmount
+// autoTunedFlushPacer rate limits memtable flushing using the same AIMD
+// control loop as autoTunedCompactionPacer: it measures the ratio of
+// actually-flushed bytes to the limiter's capacity per interval and adjusts
+// the rate accordingly, rather than relying on the memtable-size watermark
+// (PacerOptions.FlushSlowdownThresholdPercent) used by flushPacer.
+type autoTunedFlushPacer struct {
+	aimd *aimdController
 
-	now := time.Now()
-	elapsedTime := now.Sub(p.lastRefresh)
-	if elapsedTime > recalculateInterval {
-		p.lastRefresh = now
+	prevBytesIterated uint64
+}
 
-		drainedPercent := uint64(p.curAmount / p.maxCapacity) *
-			uint64(elapsedTime / recalculateInterval) * 100
+func newAutoTunedFlushPacer(opts PacerOptions, metrics *Metrics) (*autoTunedFlushPacer, error) {
+	opts = *opts.EnsureDefaults()
+	limiter, err := resolveTunableLimiter(opts.RateLimiter, opts.Flush.MaxRate)
+	if err != nil {
+		return nil, err
+	}
+	return &autoTunedFlushPacer{
+		aimd: newAIMDController(pacerKindFlush, opts.Flush, limiter, metrics, opts.EventListener, nil),
+	}, nil
+}
 
-		if drainedPercent < lowWatermarkPercent {
-			limit := uint64(p.limiter.Limit()) * (100 - adjustmentFactorPercent) / 100
-			if uint64(limit) > minimumRate {
-				p.limiter.SetLimit(rate.Limit(limit))
-			}
-		} else if drainedPercent > highWatermarkPercent {
-			limit := uint64(p.limiter.Limit()) * (100 + adjustmentFactorPercent) / 100
-			if uint64(limit) < maximumRate {
-				p.limiter.SetLimit(rate.Limit(limit))
-			}
-		}
+func (p *autoTunedFlushPacer) maybeThrottle(bytesIterated uint64) error {
+	var flushAmount int
+	if bytesIterated > p.prevBytesIterated {
+		flushAmount = int(bytesIterated - p.prevBytesIterated)
+	}
+	p.prevBytesIterated = bytesIterated
+	return p.aimd.throttle(flushAmount)
+}
 
-		p.curAmount = 0
-		p.maxCapacity = int(p.limiter.Limit()) / refillsPerSecond
+// newFlushPacerFor returns the flush pacer selected by opts.AutoTunePacers:
+// the auto-tuned variant if enabled, otherwise the manual-watermark
+// flushPacer. It returns an error if opts.AutoTunePacers is set and
+// opts.RateLimiter doesn't satisfy TunableRateLimiter.
+func newFlushPacerFor(env flushPacerEnv, opts PacerOptions, metrics *Metrics) (pacer, error) {
+	if opts.AutoTunePacers {
+		return newAutoTunedFlushPacer(opts, metrics)
 	}
+	return newFlushPacer(env, opts, metrics), nil
+}
 
-	return nil
+// newCompactionPacerFor returns the compaction pacer selected by
+// opts.AutoTunePacers: the auto-tuned variant if enabled, otherwise the
+// manual-watermark compactionPacer. It returns an error if
+// opts.AutoTunePacers is set and opts.RateLimiter doesn't satisfy
+// TunableRateLimiter.
+func newCompactionPacerFor(env compactionPacerEnv, opts PacerOptions, metrics *Metrics) (pacer, error) {
+	if opts.AutoTunePacers {
+		return newAutoTunedCompactionPacer(opts, metrics)
+	}
+	return newCompactionPacer(env, opts, metrics), nil
 }
 
-type noopPacer struct {}
+type noopPacer struct{}
 
 func (p *noopPacer) maybeThrottle(_ uint64) error {
 	return nil
@@ -0,0 +1,119 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"context"
+	"time"
+)
+
+// PacerTraceEntry is one sample of a write-rate trace: at Elapsed time since
+// the start of the trace, Bytes additional bytes had been written, with
+// L0Files and QueuedMemtables describing the LSM shape at that point. It is
+// the input format consumed by SimulatePacer and by
+// `pebble tool pacer simulate`.
+type PacerTraceEntry struct {
+	Elapsed         time.Duration
+	Bytes           uint64
+	L0Files         int
+	QueuedMemtables int
+}
+
+// PacerSimulationStep reports the auto-tuned compaction pacer's state after
+// processing one PacerTraceEntry.
+type PacerSimulationStep struct {
+	// Elapsed is copied from the input PacerTraceEntry.
+	Elapsed time.Duration
+	// Limit is the pacer's rate limit, in bytes/sec, after processing this
+	// entry.
+	Limit uint64
+	// RateChanged reports whether this entry caused the pacer to adjust
+	// Limit.
+	RateChanged bool
+	// Slowdown reports whether this entry's L0Files/QueuedMemtables would
+	// have crossed WriteStallController's soft (slowdown) threshold.
+	Slowdown bool
+	// Stopped reports whether this entry's L0Files/QueuedMemtables would
+	// have crossed WriteStallController's hard (stop) threshold.
+	Stopped bool
+}
+
+// simClock is a clock an aimdController can be pointed at instead of
+// time.Now, so that a simulation's notion of elapsed time comes from the
+// trace being replayed rather than from however long this process actually
+// takes to run the loop below.
+type simClock struct {
+	now time.Time
+}
+
+func (c *simClock) Now() time.Time { return c.now }
+
+// simRateLimiter is a TunableRateLimiter that never blocks and never drops:
+// WaitN and AllowN return immediately regardless of amount. SimulatePacer
+// only cares about how the configured limit evolves, not about actually
+// pacing anything, so there is no reason for a simulation run to sleep for
+// real (which is both slow and, since it reads the wall clock, at odds with
+// driving the pacer off the trace's own timestamps).
+type simRateLimiter struct {
+	limit float64
+	burst int
+}
+
+func (l *simRateLimiter) WaitN(ctx context.Context, n int) error { return nil }
+func (l *simRateLimiter) AllowN(now time.Time, n int) bool       { return true }
+func (l *simRateLimiter) Burst() int                             { return l.burst }
+func (l *simRateLimiter) Limit() float64                         { return l.limit }
+func (l *simRateLimiter) SetLimit(limit float64)                 { l.limit = limit }
+
+// SimulatePacer replays a write-rate trace through an auto-tuned compaction
+// pacer configured with opts.PacerOptions and returns the resulting sequence
+// of rate changes and write-stall classifications (per opts' L0/memtable
+// triggers). It is used by `pebble tool pacer simulate` to make pacer tuning
+// data-driven instead of guesswork.
+//
+// Unlike driving a real pacer, SimulatePacer never sleeps: the AIMD control
+// loop is driven off a clock set from each PacerTraceEntry.Elapsed, so a
+// trace with gaps between writes produces different (and reproducible)
+// results than one without, without this function taking wall-clock time
+// proportional to the trace it replays.
+func SimulatePacer(opts Options, trace []PacerTraceEntry) []PacerSimulationStep {
+	opts = *opts.EnsureDefaults()
+	pacerOpts := *opts.PacerOptions.Compaction.EnsureDefaults()
+
+	clock := &simClock{}
+	limiter := &simRateLimiter{limit: float64(pacerOpts.MaxRate), burst: int(pacerOpts.MaxRate)}
+	// Pass clock.Now to newAIMDController so lastRefresh is seeded from the
+	// simulated clock directly, rather than from the real wall clock and
+	// then overwritten: throttle's first elapsedTime calculation compares
+	// against whatever lastRefresh was stamped with, so the two must agree
+	// from the start or every interval looks like it has already elapsed (if
+	// lastRefresh is ahead of the clock) or none ever will (if it's behind).
+	aimd := newAIMDController(pacerKindCompaction, pacerOpts, limiter, nil, opts.PacerOptions.EventListener, clock.Now)
+
+	steps := make([]PacerSimulationStep, 0, len(trace))
+	var start time.Time
+	for _, entry := range trace {
+		clock.now = start.Add(entry.Elapsed)
+
+		before := limiter.Limit()
+		// throttle can only fail if its context is canceled, which cannot
+		// happen here since simRateLimiter ignores its context argument.
+		_ = aimd.throttle(int(entry.Bytes))
+		after := limiter.Limit()
+
+		slowdown, stopped := writeStallTrigger(
+			opts.L0SlowdownWritesTrigger, opts.L0StopWritesTrigger, opts.MemTableStopWritesThreshold,
+			entry.L0Files, entry.QueuedMemtables)
+
+		steps = append(steps, PacerSimulationStep{
+			Elapsed:     entry.Elapsed,
+			Limit:       uint64(after),
+			RateChanged: after != before,
+			Slowdown:    slowdown,
+			Stopped:     stopped,
+		})
+	}
+	return steps
+}
@@ -0,0 +1,142 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package tool
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petermattis/pebble"
+	"github.com/spf13/cobra"
+)
+
+// pacerT implements the introspection tools for the pacer subsystem.
+type pacerT struct {
+	Root     *cobra.Command
+	Simulate *cobra.Command
+
+	// opts is populated from the Simulate command's flags.
+	opts pebble.Options
+}
+
+func newPacer() *pacerT {
+	p := &pacerT{}
+	p.Root = &cobra.Command{
+		Use:   "pacer",
+		Short: "pacer introspection tools",
+	}
+	p.Simulate = &cobra.Command{
+		Use:   "simulate <trace-file>",
+		Short: "replay a write-rate trace through the auto-tuned pacer",
+		Long: `
+Replay a write-rate trace through the auto-tuned compaction pacer and print
+the resulting rate changes and write-stall events. The trace file format is
+one line per sample:
+
+    <elapsed-ms> <bytes-written> [l0-files] [queued-memtables]
+
+sorted by elapsed time; the last two fields are optional and default to 0,
+but must be given together (a line has either two fields or four).
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: p.runSimulate,
+	}
+
+	f := p.Simulate.Flags()
+	f.Uint64Var(&p.opts.PacerOptions.Compaction.MinRate, "min-rate", 0, "minimum auto-tuned rate, in bytes/sec (0 uses the Pebble default)")
+	f.Uint64Var(&p.opts.PacerOptions.Compaction.MaxRate, "max-rate", 0, "maximum (and starting) auto-tuned rate, in bytes/sec (0 uses the Pebble default)")
+	f.IntVar(&p.opts.PacerOptions.Compaction.LowWatermarkPercent, "low-watermark-percent", 0, "drained-percent floor below which the rate is shrunk (0 uses the Pebble default)")
+	f.IntVar(&p.opts.PacerOptions.Compaction.HighWatermarkPercent, "high-watermark-percent", 0, "drained-percent ceiling above which the rate is grown (0 uses the Pebble default)")
+	f.IntVar(&p.opts.PacerOptions.Compaction.AdjustmentFactorPercent, "adjustment-factor-percent", 0, "percentage the rate is grown/shrunk by on each adjustment (0 uses the Pebble default)")
+	f.IntVar(&p.opts.PacerOptions.Compaction.RefillsPerSecond, "refills-per-second", 0, "token bucket refills per second (0 uses the Pebble default)")
+	f.IntVar(&p.opts.L0SlowdownWritesTrigger, "l0-slowdown-trigger", 0, "L0 file count that crosses the soft write-stall threshold (0 uses the Pebble default)")
+	f.IntVar(&p.opts.L0StopWritesTrigger, "l0-stop-trigger", 0, "L0 file count that crosses the hard write-stall threshold (0 uses the Pebble default)")
+	f.IntVar(&p.opts.MemTableStopWritesThreshold, "memtable-stop-threshold", 0, "queued memtable count that crosses the hard write-stall threshold (0 uses the Pebble default)")
+
+	p.Root.AddCommand(p.Simulate)
+	return p
+}
+
+func (p *pacerT) runSimulate(cmd *cobra.Command, args []string) error {
+	trace, err := readPacerTrace(args[0])
+	if err != nil {
+		return err
+	}
+
+	steps := pebble.SimulatePacer(p.opts, trace)
+	w := cmd.OutOrStdout()
+	for _, step := range steps {
+		var flags []string
+		if step.RateChanged {
+			flags = append(flags, "rate-changed")
+		}
+		if step.Slowdown {
+			flags = append(flags, "write-slowdown")
+		}
+		if step.Stopped {
+			flags = append(flags, "write-stop")
+		}
+		fmt.Fprintf(w, "%s\tlimit=%d B/s\t%s\n", step.Elapsed, step.Limit, strings.Join(flags, ","))
+	}
+	return nil
+}
+
+// readPacerTrace parses a trace file where each line is
+// "<elapsed-ms> <bytes-written> [l0-files] [queued-memtables]".
+func readPacerTrace(path string) ([]pebble.PacerTraceEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var trace []pebble.PacerTraceEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 4 {
+			return nil, fmt.Errorf(
+				"pebble: malformed pacer trace line %q (want \"<elapsed-ms> <bytes> [l0-files] [queued-memtables]\")", line)
+		}
+
+		elapsedMs, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		bytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		entry := pebble.PacerTraceEntry{
+			Elapsed: time.Duration(elapsedMs) * time.Millisecond,
+			Bytes:   bytes,
+		}
+		if len(fields) == 4 {
+			l0Files, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return nil, err
+			}
+			queuedMemtables, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return nil, err
+			}
+			entry.L0Files = l0Files
+			entry.QueuedMemtables = queuedMemtables
+		}
+		trace = append(trace, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return trace, nil
+}
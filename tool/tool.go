@@ -27,6 +27,7 @@ type T struct {
 	manifest  *manifestT
 	sstable   *sstableT
 	wal       *walT
+	pacer     *pacerT
 	opts      base.Options
 	comparers sstable.Comparers
 	mergers   sstable.Mergers
@@ -46,11 +47,13 @@ func New() *T {
 	t.manifest = newManifest(&t.opts)
 	t.sstable = newSSTable(&t.opts, t.comparers, t.mergers)
 	t.wal = newWAL(&t.opts)
+	t.pacer = newPacer()
 	t.Commands = []*cobra.Command{
 		t.db.Root,
 		t.manifest.Root,
 		t.sstable.Root,
 		t.wal.Root,
+		t.pacer.Root,
 	}
 	return t
 }
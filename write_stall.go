@@ -0,0 +1,171 @@
+// Copyright 2019 The LevelDB-Go and Pebble Authors. All rights reserved. Use
+// of this source code is governed by a BSD-style license that can be found in
+// the LICENSE file.
+
+package pebble
+
+import (
+	"sync"
+	"time"
+)
+
+// writeStallSlowdownDuration is how long a write sleeps for each time it
+// observes the soft (slowdown) threshold crossed. It is intentionally short:
+// the intent is to throttle the rate of incoming writes, not to pause them,
+// and each later call to WaitForAdmission checks the threshold again.
+const writeStallSlowdownDuration = time.Millisecond
+
+// WriteStallController is the safety net that sits above the flush and
+// compaction pacers. The pacers keep background IO in line with the rate of
+// foreground writes, but they cannot, by themselves, stop writes from
+// outrunning the LSM (for example when compactions fall behind and L0
+// accumulates files faster than the pacer's debt estimate can react).
+// WriteStallController consults the current L0 file count and the number of
+// queued (immutable) memtables and, via WaitForAdmission, slows or blocks the
+// writer when the LSM falls behind.
+type WriteStallController struct {
+	l0SlowdownWritesTrigger     int
+	l0StopWritesTrigger         int
+	memTableStopWritesThreshold int
+
+	metrics *Metrics
+
+	mu struct {
+		sync.Mutex
+		cond            sync.Cond
+		l0Files         int
+		queuedMemtables int
+		// activeStalls is the number of goroutines currently blocked in
+		// WaitForAdmission's hard-stop wait. stallStart is the time the
+		// first of them started waiting, so that CurrentStall reports the
+		// longest-outstanding stall rather than the most recent entrant.
+		activeStalls int
+		stallStart   time.Time
+	}
+}
+
+// newWriteStallController creates a WriteStallController configured from
+// opts. metrics may be nil, in which case stall statistics are not recorded.
+func newWriteStallController(opts *Options, metrics *Metrics) *WriteStallController {
+	opts = opts.EnsureDefaults()
+	c := &WriteStallController{
+		l0SlowdownWritesTrigger:     opts.L0SlowdownWritesTrigger,
+		l0StopWritesTrigger:         opts.L0StopWritesTrigger,
+		memTableStopWritesThreshold: opts.MemTableStopWritesThreshold,
+		metrics:                     metrics,
+	}
+	c.mu.cond.L = &c.mu.Mutex
+	return c
+}
+
+// Update reports the current L0 file count and queued (immutable) memtable
+// count. The DB's flush, compaction and memtable rotation paths do not call
+// Update; the controller acts only on the counts most recently passed here.
+// If the write stall condition has cleared, writers blocked in
+// WaitForAdmission are woken.
+func (c *WriteStallController) Update(l0Files, queuedMemtables int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mu.l0Files = l0Files
+	c.mu.queuedMemtables = queuedMemtables
+	if !c.stoppedLocked() {
+		c.mu.cond.Broadcast()
+	}
+}
+
+func (c *WriteStallController) stoppedLocked() bool {
+	_, stopped := writeStallTrigger(
+		c.l0SlowdownWritesTrigger, c.l0StopWritesTrigger, c.memTableStopWritesThreshold,
+		c.mu.l0Files, c.mu.queuedMemtables)
+	return stopped
+}
+
+func (c *WriteStallController) slowedLocked() bool {
+	slowdown, _ := writeStallTrigger(
+		c.l0SlowdownWritesTrigger, c.l0StopWritesTrigger, c.memTableStopWritesThreshold,
+		c.mu.l0Files, c.mu.queuedMemtables)
+	return slowdown
+}
+
+// writeStallTrigger reports whether l0Files/queuedMemtables would cross the
+// soft (slowdown) or hard (stop) write-stall thresholds. It is shared by
+// WriteStallController and by SimulatePacer, which classifies a trace's
+// entries the same way without driving an actual WriteStallController.
+func writeStallTrigger(
+	l0SlowdownWritesTrigger, l0StopWritesTrigger, memTableStopWritesThreshold, l0Files, queuedMemtables int,
+) (slowdown, stopped bool) {
+	stopped = l0Files >= l0StopWritesTrigger || queuedMemtables >= memTableStopWritesThreshold
+	slowdown = l0Files >= l0SlowdownWritesTrigger
+	return slowdown, stopped
+}
+
+// WaitForAdmission checks whether a write may be admitted to the LSM; DB.Apply
+// and batch commit do not call it. If the soft (slowdown) threshold has been
+// crossed, it sleeps briefly so that incoming writes are throttled without
+// being paused outright. If the hard (stop) threshold has been crossed, it
+// blocks until Update reports counts back under the limit. A
+// write that crosses both thresholds is counted as a single stalled write,
+// not two.
+func (c *WriteStallController) WaitForAdmission() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.slowedLocked() && !c.stoppedLocked() {
+		return
+	}
+
+	start := time.Now()
+	c.beginStallLocked(start)
+	defer c.endStallLocked()
+
+	if c.slowedLocked() && !c.stoppedLocked() {
+		c.mu.Unlock()
+		time.Sleep(writeStallSlowdownDuration)
+		c.mu.Lock()
+	}
+
+	for c.stoppedLocked() {
+		c.mu.cond.Wait()
+	}
+
+	c.recordStallLocked(time.Since(start))
+}
+
+// beginStallLocked records that a new writer has begun waiting in
+// WaitForAdmission. Only the first concurrently-stalled writer sets
+// stallStart, so CurrentStall reports how long the oldest stalled writer has
+// been waiting.
+func (c *WriteStallController) beginStallLocked(start time.Time) {
+	if c.mu.activeStalls == 0 {
+		c.mu.stallStart = start
+	}
+	c.mu.activeStalls++
+}
+
+// endStallLocked records that a writer has been admitted.
+func (c *WriteStallController) endStallLocked() {
+	c.mu.activeStalls--
+	if c.mu.activeStalls == 0 {
+		c.mu.stallStart = time.Time{}
+	}
+}
+
+func (c *WriteStallController) recordStallLocked(d time.Duration) {
+	if c.metrics == nil {
+		return
+	}
+	c.metrics.WriteStall.Count++
+	c.metrics.WriteStall.Duration += d
+}
+
+// CurrentStall returns the duration of the write stall presently in
+// progress, or zero if no write is currently stalled. Nothing stores this
+// value into Metrics.WriteStall.Current.
+func (c *WriteStallController) CurrentStall() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.mu.stallStart.IsZero() {
+		return 0
+	}
+	return time.Since(c.mu.stallStart)
+}